@@ -0,0 +1,148 @@
+package radix
+
+import "fmt"
+
+// assignString, assignInt, assignFloat, assignBool, and assignSlice each
+// unmarshal a decoded resp value into rcv, which is expected to be a
+// pointer (or nil, in which case the value is simply discarded).
+//
+// These intentionally only support the handful of destination types Cmd.Rcv
+// is documented to accept; anything more exotic should decode into an
+// interface{} and be type-asserted by the caller.
+
+func assignString(rcv interface{}, s string) error {
+	switch rcv := rcv.(type) {
+	case nil:
+		return nil
+	case *string:
+		*rcv = s
+		return nil
+	case *[]byte:
+		*rcv = append((*rcv)[:0], s...)
+		return nil
+	case *interface{}:
+		*rcv = s
+		return nil
+	default:
+		return fmt.Errorf("radix: can't unmarshal string into %T", rcv)
+	}
+}
+
+func assignInt(rcv interface{}, n int64) error {
+	switch rcv := rcv.(type) {
+	case nil:
+		return nil
+	case *int64:
+		*rcv = n
+		return nil
+	case *int:
+		*rcv = int(n)
+		return nil
+	case *interface{}:
+		*rcv = n
+		return nil
+	default:
+		return fmt.Errorf("radix: can't unmarshal int into %T", rcv)
+	}
+}
+
+func assignFloat(rcv interface{}, f float64) error {
+	switch rcv := rcv.(type) {
+	case nil:
+		return nil
+	case *float64:
+		*rcv = f
+		return nil
+	case *interface{}:
+		*rcv = f
+		return nil
+	default:
+		return fmt.Errorf("radix: can't unmarshal float into %T", rcv)
+	}
+}
+
+func assignBool(rcv interface{}, b bool) error {
+	switch rcv := rcv.(type) {
+	case nil:
+		return nil
+	case *bool:
+		*rcv = b
+		return nil
+	case *interface{}:
+		*rcv = b
+		return nil
+	default:
+		return fmt.Errorf("radix: can't unmarshal bool into %T", rcv)
+	}
+}
+
+// assignMap unmarshals a decoded RESP3 map into rcv. pairs holds the
+// flattened field/value sequence (i.e. len(pairs) == 2*fieldCount), as
+// decoded by decodeMap.
+func assignMap(rcv interface{}, pairs []interface{}) error {
+	toMap := func() (map[string]interface{}, error) {
+		m := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			k, ok := pairs[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("radix: can't use %T as a map key", pairs[i])
+			}
+			m[k] = pairs[i+1]
+		}
+		return m, nil
+	}
+
+	switch rcv := rcv.(type) {
+	case nil:
+		return nil
+	case *map[string]interface{}:
+		m, err := toMap()
+		if err != nil {
+			return err
+		}
+		*rcv = m
+		return nil
+	case *interface{}:
+		m, err := toMap()
+		if err != nil {
+			return err
+		}
+		*rcv = m
+		return nil
+	case *[]interface{}:
+		// caller wants the flattened field/value pairs rather than a map
+		*rcv = pairs
+		return nil
+	default:
+		return fmt.Errorf("radix: can't unmarshal map into %T", rcv)
+	}
+}
+
+func assignSlice(rcv interface{}, vals []interface{}) error {
+	switch rcv := rcv.(type) {
+	case nil:
+		return nil
+	case *[]interface{}:
+		*rcv = vals
+		return nil
+	case *[]string:
+		ss := make([]string, len(vals))
+		for i, v := range vals {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("radix: can't unmarshal %T into string", v)
+			}
+			ss[i] = s
+		}
+		*rcv = ss
+		return nil
+	case *interface{}:
+		*rcv = vals
+		return nil
+	case *PushMessage:
+		*rcv = PushMessage(vals)
+		return nil
+	default:
+		return fmt.Errorf("radix: can't unmarshal array into %T", rcv)
+	}
+}
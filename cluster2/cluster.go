@@ -5,14 +5,27 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
-	radix "github.com/mediocregopher/radix.v2"
+	radix "github.com/v2e4lisp/radix.v3"
 )
 
+// defaultSyncEvery is used as Cluster's background sync period when
+// ClusterConfig.SyncEvery isn't set.
+const defaultSyncEvery = 30 * time.Second
+
+// maxRedirects is the number of MOVED/ASK redirects Do will follow for a
+// single Action before giving up and returning the last error seen. This
+// bounds retries during resharding, when a command can bounce between a
+// couple of nodes a few times before the topology settles.
+const maxRedirects = 5
+
 type errClient struct {
 	err error
 }
@@ -21,40 +34,74 @@ func (ec errClient) Do(radix.Action) error {
 	return ec.err
 }
 
+func (ec errClient) DoContext(_ context.Context, _ radix.Action) error {
+	return ec.err
+}
+
 func (ec errClient) Close() error {
 	return nil
 }
 
+// ClusterConfig configures a Cluster created via NewCluster.
+type ClusterConfig struct {
+	// PoolFunc is used to make the internal pools for the instances
+	// discovered in the cluster and all new ones found later. If nil,
+	// radix.DefaultPoolFunc is used.
+	PoolFunc radix.PoolFunc
+
+	// SyncEvery is how often Cluster refreshes its topology in the
+	// background. If zero, it defaults to 30 seconds.
+	SyncEvery time.Duration
+
+	// OnError, if set, is called whenever a background Sync (the periodic
+	// one, or the one a MOVED redirect starts) fails. Those errors are also
+	// sent to ErrCh whether or not this is set.
+	OnError func(error)
+
+	// OnTopoChange, if set, is called after any Sync (background or
+	// manual, via Sync/SyncContext) which finds that the cluster's slot
+	// map has changed since the last one.
+	OnTopoChange func(old, new Topo)
+}
+
 // Cluster contains all information about a redis cluster needed to interact
 // with it, including a set of pools to each of its instances. All methods on
 // Cluster are thread-safe
 type Cluster struct {
-	pf radix.PoolFunc
+	pf           radix.PoolFunc
+	syncEveryD   time.Duration
+	onError      func(error)
+	onTopoChange func(old, new Topo)
 
 	sync.RWMutex
 	pools map[string]radix.Client
 	tt    Topo
 
-	errCh   chan error // TODO expose this somehow
+	errCh   chan error
 	closeCh chan struct{}
 }
 
 // NewCluster initializes and returns a Cluster instance. It will try every
 // address given until it finds a usable one. From there it use CLUSTER SLOTS to
 // discover the cluster topology and make all the necessary connections.
-//
-// The PoolFunc is used to make the internal pools for the instances discovered
-// here and all new ones in the future. If nil is given then
-// radix.DefaultPoolFunc will be used.
-func NewCluster(pf radix.PoolFunc, addrs ...string) (*Cluster, error) {
+func NewCluster(cfg ClusterConfig, addrs ...string) (*Cluster, error) {
+	pf := cfg.PoolFunc
 	if pf == nil {
 		pf = radix.DefaultPoolFunc
 	}
+	syncEvery := cfg.SyncEvery
+	if syncEvery <= 0 {
+		syncEvery = defaultSyncEvery
+	}
+
 	c := &Cluster{
-		pf:      pf,
-		pools:   map[string]radix.Client{},
-		closeCh: make(chan struct{}),
-		errCh:   make(chan error, 1),
+		pf:           pf,
+		syncEveryD:   syncEvery,
+		onError:      cfg.OnError,
+		onTopoChange: cfg.OnTopoChange,
+		pools:        map[string]radix.Client{},
+		closeCh:      make(chan struct{}),
+		errCh:        make(chan error, 1),
 	}
 
 	// make a pool to base the cluster on
@@ -74,7 +121,7 @@ func NewCluster(pf radix.PoolFunc, addrs ...string) (*Cluster, error) {
 		return nil, err
 	}
 
-	go c.syncEvery(30 * time.Second) // TODO make period configurable?
+	go c.syncEvery(c.syncEveryD)
 
 	return c, nil
 }
@@ -84,6 +131,19 @@ func (c *Cluster) err(err error) {
 	case c.errCh <- err:
 	default:
 	}
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// ErrCh returns a channel on which errors from Cluster's background syncs
+// (the periodic loop and the Sync a MOVED redirect starts) are delivered,
+// so long-running services can log/alert on them
+// instead of losing them silently. It has a small buffer; an error which
+// arrives while the channel is full is dropped rather than blocking the
+// sync loop.
+func (c *Cluster) ErrCh() <-chan error {
+	return c.errCh
 }
 
 // attempts to create a pool at the given address. The pool will be stored under
@@ -103,10 +163,23 @@ func (c *Cluster) dirtyNewPool(addr string) (radix.Client, error) {
 }
 
 func (c *Cluster) anyPool() radix.Client {
+	return c.anyPoolContext(context.Background())
+}
+
+// anyPoolContext is like anyPool, but gives up and returns ctx.Err() if ctx
+// is canceled or its deadline passes before a usable pool is found, rather
+// than working through every known node regardless of how long that takes.
+func (c *Cluster) anyPoolContext(ctx context.Context) radix.Client {
 	c.RLock()
 	defer c.RUnlock()
 	for _, p := range c.pools {
-		err := p.Do(radix.CmdNoKey(nil, "PING"))
+		select {
+		case <-ctx.Done():
+			return errClient{err: ctx.Err()}
+		default:
+		}
+
+		err := p.DoContext(ctx, radix.Cmd{}.C("PING"))
 		if err != nil {
 			// TODO If there's an error we don't log it or anything, since node
 			// failures are "normal". Maybe we should?
@@ -125,7 +198,7 @@ func (c *Cluster) Topo() (Topo, error) {
 
 func (c *Cluster) topo(p radix.Client) (Topo, error) {
 	var tt Topo
-	err := p.Do(radix.Cmd(&tt, "CLUSTER", "SLOTS"))
+	err := p.Do(radix.Cmd{}.C("CLUSTER").A("SLOTS").R(&tt))
 	return tt, err
 }
 
@@ -137,6 +210,13 @@ func (c *Cluster) Sync() error {
 	return c.sync(c.anyPool())
 }
 
+// SyncContext is like Sync, but gives up and returns ctx.Err() if ctx is
+// canceled before a node can be reached for the topology refresh, instead
+// of blocking on nodes which may be dead.
+func (c *Cluster) SyncContext(ctx context.Context) error {
+	return c.sync(c.anyPoolContext(ctx))
+}
+
 func (c *Cluster) sync(p radix.Client) error {
 	tt, err := c.topo(p)
 	if err != nil {
@@ -144,11 +224,12 @@ func (c *Cluster) sync(p radix.Client) error {
 	}
 
 	c.Lock()
-	defer c.Unlock()
+	old := c.tt
 	c.tt = tt
 
 	for _, t := range tt {
 		if _, err := c.dirtyNewPool(t.Addr); err != nil {
+			c.Unlock()
 			return fmt.Errorf("error connecting to %s: %s", t.Addr, err)
 		}
 	}
@@ -160,6 +241,11 @@ func (c *Cluster) sync(p radix.Client) error {
 			delete(c.pools, addr)
 		}
 	}
+	c.Unlock()
+
+	if c.onTopoChange != nil && !reflect.DeepEqual(old.Map(), tt.Map()) {
+		c.onTopoChange(old, tt)
+	}
 
 	return nil
 }
@@ -183,33 +269,328 @@ func (c *Cluster) syncEvery(d time.Duration) {
 }
 
 // Do performs an Action on a redis instance in the cluster, with the instance
-// being determeined by the key returned from the Action's Key() method.
+// being determeined by the key returned from the Action's Key() method. If
+// the instance replies with a MOVED or ASK redirect, Do follows it (up to
+// maxRedirects times) rather than returning the redirect to the caller.
 func (c *Cluster) Do(a radix.Action) error {
+	return c.do(a, 0)
+}
+
+// multiKeyAction is implemented by Actions, such as a radix.Pipeline, which
+// may touch more than one key at once. Cluster uses it to make sure every
+// key involved hashes to the same slot before routing the Action, whole, to
+// a single instance; Cluster never splits an Action across instances.
+type multiKeyAction interface {
+	radix.Action
+	Keys() [][]byte
+}
+
+func (c *Cluster) do(a radix.Action, redirects int) error {
+	if mk, ok := a.(multiKeyAction); ok {
+		if err := requireSingleSlot(mk.Keys()); err != nil {
+			return err
+		}
+	}
+
 	k := a.Key()
 	if k == nil {
 		return c.anyPool().Do(a)
 	}
 
 	s := Slot(k)
+	c.RLock()
+	var addr string
+	var ok bool
+	for _, t := range c.tt {
+		if s < t.Slots[0] || s >= t.Slots[1] {
+			continue
+		}
+		addr, ok = t.Addr, true
+		break
+	}
+	c.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unexpected: no known address for slot %d", s)
+	}
+	return c.doAt(a, addr, redirects)
+}
+
+// doAt dispatches a directly against the pool for addr, bypassing the slot
+// lookup in do, and follows any further MOVED/ASK redirect the node
+// returns. It's used both for the initial dispatch (from do, once the slot
+// has been resolved to an addr) and to retry against a MOVED target
+// directly - re-deriving the slot from do's topology would just route
+// straight back to the same (still-cached) node until Sync catches up.
+func (c *Cluster) doAt(a radix.Action, addr string, redirects int) error {
+	c.RLock()
+	p, poolOK := c.pools[addr]
+	c.RUnlock()
+	if !poolOK {
+		return fmt.Errorf("unexpected: no pool for address %q", addr)
+	}
+
+	err := p.Do(a)
+	if err == nil || redirects >= maxRedirects {
+		return err
+	}
+
+	if movedAddr, ok := parseRedirect("MOVED", err); ok {
+		if _, perr := c.newPool(movedAddr); perr != nil {
+			return perr
+		}
+		go func() {
+			if err := c.Sync(); err != nil {
+				c.err(err)
+			}
+		}()
+
+		time.Sleep(redirectBackoff(redirects))
+		return c.doAt(a, movedAddr, redirects+1)
+	}
+
+	if askAddr, ok := parseRedirect("ASK", err); ok {
+		if aerr := c.doAsk(a, askAddr); aerr != nil {
+			time.Sleep(redirectBackoff(redirects))
+			return c.do(a, redirects+1)
+		}
+		return nil
+	}
+
+	return err
+}
+
+// requireSingleSlot returns an error if the given keys don't all hash to
+// the same slot. A multiKeyAction spanning multiple slots can't be sent to
+// a single instance, and splitting it transparently would mean splitting
+// up its reply too, which Cluster has no way to reassemble generically.
+func requireSingleSlot(keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	first := Slot(keys[0])
+	for _, k := range keys[1:] {
+		if s := Slot(k); s != first {
+			return errors.New("cluster: action's keys span more than one hash slot")
+		}
+	}
+	return nil
+}
+
+// doAsk dials addr directly (bypassing the normal pool, since ASKING only
+// applies to the single command following it on the same connection),
+// issues ASKING, and then replays a on that connection.
+func (c *Cluster) doAsk(a radix.Action, addr string) error {
+	conn, err := radix.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := (radix.Cmd{}.C("ASKING")).Run(conn); err != nil {
+		return err
+	}
+	return a.Run(conn)
+}
+
+// newPool creates/reuses a pool at addr the same way dirtyNewPool does, but
+// takes the lock itself so it can be called from outside sync.
+func (c *Cluster) newPool(addr string) (radix.Client, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.dirtyNewPool(addr)
+}
+
+// parseRedirect checks whether err is a MOVED/ASK redirect of the given
+// kind (e.g. "MOVED" or "ASK") and, if so, returns the address it points
+// to.
+func parseRedirect(kind string, err error) (addr string, ok bool) {
+	parts := strings.Fields(err.Error())
+	if len(parts) != 3 || parts[0] != kind {
+		return "", false
+	}
+	return parts[2], true
+}
+
+// redirectBackoff returns how long to wait before following the nth
+// redirect, growing exponentially up to a cap so a resharding cluster
+// doesn't get hammered with retries.
+func redirectBackoff(attempt int) time.Duration {
+	d := 10 * time.Millisecond * time.Duration(1<<uint(attempt))
+	if d > 500*time.Millisecond {
+		d = 500 * time.Millisecond
+	}
+	return d
+}
+
+// DoContext is like Do, but resolves the target pool the same way Do does
+// and then dispatches via that pool's own DoContext, so ctx actually ends
+// up as a deadline on the node's net.Conn (and that conn is discarded
+// rather than reused if ctx is canceled) instead of just abandoning a
+// goroutine to keep blocking on a dead node. Unlike Do, it doesn't follow
+// MOVED/ASK redirects - there's no good deadline-respecting way to retry
+// once ctx's clock is already running, so a redirect reply is returned to
+// the caller as-is.
+func (c *Cluster) DoContext(ctx context.Context, a radix.Action) error {
+	if mk, ok := a.(multiKeyAction); ok {
+		if err := requireSingleSlot(mk.Keys()); err != nil {
+			return err
+		}
+	}
+
+	k := a.Key()
+	if k == nil {
+		return c.anyPoolContext(ctx).DoContext(ctx, a)
+	}
+
+	s := Slot(k)
+	c.RLock()
+	var addr string
+	var ok bool
 	for _, t := range c.tt {
 		if s < t.Slots[0] || s >= t.Slots[1] {
 			continue
 		}
-		p, ok := c.pools[t.Addr]
-		if !ok {
-			return fmt.Errorf("unexpected: no pool for address %q", t.Addr)
+		addr, ok = t.Addr, true
+		break
+	}
+	p, poolOK := c.pools[addr]
+	c.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unexpected: no known address for slot %d", s)
+	} else if !poolOK {
+		return fmt.Errorf("unexpected: no pool for address %q", addr)
+	}
+	return p.DoContext(ctx, a)
+}
+
+// PubSub returns a persistent, auto-reconnecting pubsub connection
+// subscribed via any one of the cluster's known nodes, with messages
+// delivered on msgCh. Since a cluster-mode pubsub message is broadcast to
+// every node rather than partitioned by slot, it doesn't matter which node
+// the subscription is made against.
+func (c *Cluster) PubSub(msgCh chan<- radix.PubSubMessage) radix.PubSubConn {
+	connFn := func() (radix.Conn, error) {
+		c.RLock()
+		defer c.RUnlock()
+
+		var lastErr error
+		for addr := range c.pools {
+			conn, err := radix.Dial("tcp", addr)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return conn, nil
+		}
+		if lastErr == nil {
+			lastErr = errors.New("cluster: no known redis instances to subscribe on")
 		}
-		return p.Do(a)
+		return nil, lastErr
+	}
+	return radix.PersistentPubSub("", "", connFn, msgCh)
+}
+
+// connClient adapts a single radix.Conn into a radix.Client, so things
+// written against Client (like radix.Scanner) can be pointed at one
+// specific connection rather than a Pool.
+type connClient struct {
+	radix.Conn
+}
+
+func (cc connClient) Do(a radix.Action) error {
+	return a.Run(cc.Conn)
+}
+
+func (cc connClient) DoContext(ctx context.Context, a radix.Action) error {
+	// there's only one connection here to hand the Action, so there's
+	// nothing to discard/retry on cancellation the way Pool.DoContext does
+	return a.Run(cc.Conn)
+}
+
+// Scan iterates the entire keyspace across every master currently in the
+// cluster's topology, merging their individual SCAN cursors into one
+// logical iteration. A single-node cursor can't be meaningfully composed
+// with another node's, so results come back grouped by node (each node is
+// scanned to exhaustion before the next one starts) rather than
+// interleaved.
+func (c *Cluster) Scan(opts radix.ScanOpts) *ClusterScanner {
+	c.RLock()
+	addrs := make([]string, 0, len(c.pools))
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
 	}
+	c.RUnlock()
 
-	return fmt.Errorf("unexpected: no known address for slot %d", s)
+	return &ClusterScanner{addrs: addrs, opts: opts}
+}
+
+// ClusterScanner is returned by Cluster.Scan. It has the same Next/Close
+// shape as radix.Scanner, advancing to the next master's own Scanner once
+// the current one is exhausted.
+type ClusterScanner struct {
+	addrs []string
+	opts  radix.ScanOpts
+
+	conn radix.Conn
+	cur  *radix.Scanner
+	err  error
+}
+
+// Next implements the same method as radix.Scanner.
+func (cs *ClusterScanner) Next(v *string) bool {
+	for {
+		if cs.cur != nil && cs.cur.Next(v) {
+			return true
+		}
+		if cs.cur != nil {
+			if err := cs.cur.Close(); err != nil {
+				cs.err = err
+				return false
+			}
+			cs.cur = nil
+		}
+		if cs.conn != nil {
+			cs.conn.Close()
+			cs.conn = nil
+		}
+		if len(cs.addrs) == 0 {
+			return false
+		}
+
+		addr := cs.addrs[0]
+		cs.addrs = cs.addrs[1:]
+
+		conn, err := radix.Dial("tcp", addr)
+		if err != nil {
+			cs.err = err
+			return false
+		}
+		cs.conn = conn
+		cs.cur = radix.NewScanner(connClient{conn}, cs.opts)
+	}
+}
+
+// Close implements the same method as radix.Scanner.
+func (cs *ClusterScanner) Close() error {
+	if cs.conn != nil {
+		cs.conn.Close()
+	}
+	return cs.err
 }
 
 // Close cleans up all goroutines spawned by Cluster and closes all of its
 // Pools.
+//
+// errCh is deliberately left open: a background sync already in flight (the
+// periodic syncEvery loop, or the async Sync a MOVED redirect kicks off) may
+// still call err and send on it after Close returns, and closing the
+// channel out from under that send would panic. closeCh is what stops those
+// producers; errCh is simply abandoned for the garbage collector once
+// nothing reads from it anymore.
 func (c *Cluster) Close() {
 	close(c.closeCh)
-	close(c.errCh)
 	c.Lock()
 	defer c.Unlock()
 
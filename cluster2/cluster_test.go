@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	radix "github.com/v2e4lisp/radix.v3"
+)
+
+// fakeClient is a radix.Client whose Do/DoContext are both driven by do, so
+// tests can script a node's replies (including MOVED/ASK errors) without a
+// real connection.
+type fakeClient struct {
+	do func(a radix.Action) error
+}
+
+func (f *fakeClient) Do(a radix.Action) error { return f.do(a) }
+
+func (f *fakeClient) DoContext(_ context.Context, a radix.Action) error { return f.do(a) }
+
+func (f *fakeClient) Close() error { return nil }
+
+// errProbe is what fakeClients reply to Cluster's internal PING/CLUSTER SLOTS
+// probes. Failing them keeps the async Sync a MOVED redirect starts from
+// applying an empty topology, which would drop every pool mid-test.
+var errProbe = errors.New("fake: internal probe not scripted")
+
+// fakeAction is a minimal radix.Action for use in tests; it's a distinct
+// type from radix.Cmd so a fakeClient can tell an action under test apart
+// from the PING/CLUSTER SLOTS probes Cluster issues internally.
+type fakeAction struct {
+	key []byte
+}
+
+func (a fakeAction) Key() []byte { return a.key }
+
+func (a fakeAction) Run(radix.Conn) error { return nil }
+
+func newTestCluster(pools map[string]radix.Client) *Cluster {
+	return &Cluster{
+		pf:      func(string, string) (radix.Client, error) { return nil, errors.New("pf should not be called") },
+		pools:   pools,
+		closeCh: make(chan struct{}),
+		errCh:   make(chan error, 1),
+	}
+}
+
+// TestClusterDoAtFollowsMoved is a regression test for a MOVED reply
+// looping back to the node that sent it: doAt must retry directly against
+// movedAddr's pool rather than re-resolving the slot through (still-stale)
+// topology, which would route straight back to the original node.
+func TestClusterDoAtFollowsMoved(t *testing.T) {
+	var node1Calls, node2Calls int
+	movedErr := errors.New("MOVED 1000 node2:6379")
+
+	node1 := &fakeClient{do: func(a radix.Action) error {
+		if _, ok := a.(fakeAction); !ok {
+			return errProbe // internal PING/CLUSTER SLOTS probe
+		}
+		node1Calls++
+		return movedErr
+	}}
+	node2 := &fakeClient{do: func(a radix.Action) error {
+		if _, ok := a.(fakeAction); !ok {
+			return errProbe
+		}
+		node2Calls++
+		return nil
+	}}
+
+	c := newTestCluster(map[string]radix.Client{
+		"node1:6379": node1,
+		"node2:6379": node2,
+	})
+
+	a := fakeAction{key: []byte("foo")}
+	if err := c.doAt(a, "node1:6379", 0); err != nil {
+		t.Fatalf("doAt() = %v, want nil", err)
+	}
+	if node1Calls != 1 {
+		t.Fatalf("node1 saw %d calls, want 1", node1Calls)
+	}
+	if node2Calls != 1 {
+		t.Fatalf("node2 saw %d calls, want 1 (the MOVED retry should hit it directly)", node2Calls)
+	}
+}
+
+// TestClusterDoAtGivesUpAfterMaxRedirects ensures a node stuck redirecting
+// to itself doesn't retry forever.
+func TestClusterDoAtGivesUpAfterMaxRedirects(t *testing.T) {
+	movedErr := errors.New("MOVED 1000 node1:6379")
+	var calls int
+	node1 := &fakeClient{do: func(a radix.Action) error {
+		if _, ok := a.(fakeAction); !ok {
+			return errProbe
+		}
+		calls++
+		return movedErr
+	}}
+
+	c := newTestCluster(map[string]radix.Client{"node1:6379": node1})
+
+	a := fakeAction{key: []byte("foo")}
+	err := c.doAt(a, "node1:6379", 0)
+	if err != movedErr {
+		t.Fatalf("doAt() = %v, want %v", err, movedErr)
+	}
+	if calls != maxRedirects+1 {
+		t.Fatalf("node1 saw %d calls, want %d", calls, maxRedirects+1)
+	}
+}
+
+// TestRequireSingleSlot checks that keys spanning more than one hash slot
+// are rejected, since a multiKeyAction can't be split across instances.
+func TestRequireSingleSlot(t *testing.T) {
+	if err := requireSingleSlot(nil); err != nil {
+		t.Fatalf("requireSingleSlot(nil) = %v, want nil", err)
+	}
+	if err := requireSingleSlot([][]byte{[]byte("{foo}bar"), []byte("{foo}baz")}); err != nil {
+		t.Fatalf("requireSingleSlot(same tag) = %v, want nil", err)
+	}
+	if err := requireSingleSlot([][]byte{[]byte("foo"), []byte("bar")}); err == nil {
+		t.Fatal("requireSingleSlot(different slots) = nil, want error")
+	}
+}
@@ -1,5 +1,10 @@
 package radix
 
+import (
+	"context"
+	"time"
+)
+
 // Cmd implements the Action interface and describes a single redis command to
 // be performed. The Cmd field is the name of the redis command to be performend
 // and is always required. Keys are the keys being operated on, and may be left
@@ -98,4 +103,35 @@ func (c Cmd) Run(conn Conn) error {
 		return err
 	}
 	return nil
+}
+
+// RunContext is like Run, but aborts the round-trip once ctx is done,
+// whether that's because its deadline passed or because it was canceled
+// outright (a canceled ctx with no deadline of its own still has to abort
+// the encode/decode - net.Conn only understands deadlines, so a watcher
+// goroutine forces one the moment ctx.Done fires). Either way conn is
+// closed and left unusable, same as any other Run error, since its framing
+// can no longer be trusted past an aborted write/read.
+func (c Cmd) RunContext(ctx context.Context, conn Conn) error {
+	nc := conn.NetConn()
+	if dl, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(dl)
+	}
+
+	watchDone := make(chan struct{})
+	defer close(watchDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.SetDeadline(time.Unix(0, 1)) // force any in-flight read/write to fail now
+		case <-watchDone:
+		}
+	}()
+
+	err := c.Run(conn)
+	nc.SetDeadline(time.Time{})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,376 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Action describes an entity which can perform one or more tasks using a
+// Conn. Most things one wants to do with redis can be expressed via a Cmd,
+// but Action provides a lower-level interface for anything Cmd doesn't
+// cover.
+type Action interface {
+	// Key returns a key which will be acted on. If the Action will act on
+	// more than one key, or on no keys at all, then nil should be returned.
+	Key() []byte
+
+	// Run actually performs the Action using the given Conn.
+	Run(c Conn) error
+}
+
+// Client describes an entity which can carry out Actions, e.g. a Pool or a
+// Cluster.
+type Client interface {
+	Do(a Action) error
+
+	// DoContext is like Do, but aborts and returns ctx.Err() if ctx is
+	// canceled or its deadline is exceeded before the Action completes. A
+	// connection interrupted this way must not be reused, since its state
+	// with respect to the in-flight command is no longer known.
+	DoContext(ctx context.Context, a Action) error
+
+	Close() error
+}
+
+// PushMessage is the data contained within an out-of-band RESP3 push frame
+// (type prefix '>'), e.g. a pubsub message or a client-side-caching
+// invalidation notice delivered over a connection which has negotiated
+// RESP3.
+type PushMessage []interface{}
+
+// PushHandler is called by a Conn whenever a push frame is read off the
+// wire which isn't the reply to a command which was sent. If a Conn's
+// PushHandler is nil then push frames are discarded.
+type PushHandler func(PushMessage)
+
+// Conn wraps a single network connection which synchronously
+// reads/writes data using the redis resp protocol (RESP2 or RESP3, see
+// DialOpt).
+type Conn interface {
+	// Encode writes the given value to the connection using the resp
+	// protocol.
+	Encode(interface{}) error
+
+	// Decode reads a value off the connection and unmarshals it into rcv,
+	// which should be a pointer. Frames which arrive unsolicited (i.e.
+	// RESP3 push frames) are routed to the Conn's PushHandler, if any is
+	// set, and are never visible to Decode.
+	Decode(rcv interface{}) error
+
+	// NetConn returns the net.Conn underlying this Conn, for use in setting
+	// deadlines and the like.
+	NetConn() net.Conn
+
+	Close() error
+}
+
+type conn struct {
+	net.Conn
+	rw          *bufio.ReadWriter
+	resp3       bool
+	pushHandler PushHandler
+}
+
+// DialOpt is an option which can be passed in to Dial to change its
+// behavior.
+type DialOpt func(*conn)
+
+// DialPushHandler sets the PushHandler which will be called whenever the
+// Conn receives a push frame (RESP3 only) which isn't in response to a
+// command which was sent.
+func DialPushHandler(ph PushHandler) DialOpt {
+	return func(c *conn) { c.pushHandler = ph }
+}
+
+// DialUseRESP3 has Dial negotiate RESP3 with the server (via HELLO 3) once
+// the connection is established. If the server doesn't support RESP3 the
+// connection falls back to RESP2 transparently.
+func DialUseRESP3() DialOpt {
+	return func(c *conn) { c.resp3 = true }
+}
+
+// Dial connects to the redis instance at the given network/address and
+// returns a Conn wrapping that connection.
+func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{
+		Conn: nc,
+		rw: bufio.NewReadWriter(
+			bufio.NewReader(nc),
+			bufio.NewWriter(nc),
+		),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.resp3 {
+		var unused interface{}
+		if err := c.helloAndDecode(&unused); err != nil {
+			// server doesn't understand HELLO (or doesn't support RESP3),
+			// fall back to plain RESP2 rather than failing the dial outright
+			c.resp3 = false
+		}
+	}
+
+	return c, nil
+}
+
+func (c *conn) helloAndDecode(rcv interface{}) error {
+	if err := c.Encode(Cmd{}.C("HELLO").A("3")); err != nil {
+		return err
+	}
+	return c.Decode(rcv)
+}
+
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *conn) Encode(m interface{}) error {
+	if err := encodeRESP(c.rw, m); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// Decode reads the next reply off the wire, routing any intervening push
+// frames to the PushHandler rather than treating them as the reply being
+// waited on.
+func (c *conn) Decode(rcv interface{}) error {
+	for {
+		isPush, err := peekIsPush(c.rw.Reader)
+		if err != nil {
+			return err
+		}
+		if !isPush {
+			return decodeRESP(c.rw.Reader, rcv, c.resp3)
+		}
+
+		var push PushMessage
+		if err := decodeRESP(c.rw.Reader, &push, c.resp3); err != nil {
+			return err
+		}
+		if c.pushHandler != nil {
+			c.pushHandler(push)
+		}
+	}
+}
+
+func peekIsPush(r *bufio.Reader) (bool, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	return b[0] == prefixPush, nil
+}
+
+// resp type prefixes. The RESP2 set (+-:$*) plus the RESP3 additions this
+// package understands (,#(_=%~>).
+const (
+	prefixSimpleString = '+'
+	prefixError        = '-'
+	prefixInt          = ':'
+	prefixBulkString   = '$'
+	prefixArray        = '*'
+
+	prefixDouble         = ','
+	prefixBoolean        = '#'
+	prefixBigNumber      = '('
+	prefixNull           = '_'
+	prefixVerbatimString = '='
+	prefixMap            = '%'
+	prefixSet            = '~'
+	prefixPush           = '>'
+)
+
+var errUnknownPrefix = errors.New("radix: unknown resp type prefix")
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	b, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// decodeRESP reads a single resp value off of r and unmarshals it into rcv,
+// which may be nil (in which case the value is read and discarded).
+func decodeRESP(r *bufio.Reader, rcv interface{}, resp3 bool) error {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch prefix {
+	case prefixSimpleString:
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		return assignString(rcv, string(line))
+	case prefixError:
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(line))
+	case prefixInt:
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(string(line), 10, 64)
+		if err != nil {
+			return err
+		}
+		return assignInt(rcv, n)
+	case prefixBulkString:
+		return decodeBulkString(r, rcv)
+	case prefixArray:
+		return decodeAggregate(r, rcv, resp3)
+	case prefixDouble:
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			return err
+		}
+		return assignFloat(rcv, f)
+	case prefixBoolean:
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		return assignBool(rcv, len(line) > 0 && line[0] == 't')
+	case prefixBigNumber:
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		return assignString(rcv, string(line))
+	case prefixNull:
+		if _, err := readLine(r); err != nil {
+			return err
+		}
+		return nil
+	case prefixVerbatimString:
+		return decodeVerbatimString(r, rcv)
+	case prefixMap:
+		return decodeMap(r, rcv, resp3)
+	case prefixSet:
+		return decodeAggregate(r, rcv, resp3)
+	case prefixPush:
+		return decodeAggregate(r, rcv, resp3)
+	default:
+		return fmt.Errorf("%w: %q", errUnknownPrefix, prefix)
+	}
+}
+
+func decodeBulkString(r *bufio.Reader, rcv interface{}) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(string(line))
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return nil
+	}
+
+	buf := make([]byte, n+2) // +2 for trailing \r\n
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	return assignString(rcv, string(buf[:n]))
+}
+
+// decodeVerbatimString reads a RESP3 verbatim string (prefix '='), which
+// uses the same length-prefixed framing as a bulk string but prepends a
+// 3-character type (e.g. "txt", "mkd") and a colon to the payload. That
+// type is protocol framing, not payload, so it's stripped before assigning.
+func decodeVerbatimString(r *bufio.Reader, rcv interface{}) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(string(line))
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return nil
+	}
+
+	buf := make([]byte, n+2) // +2 for trailing \r\n
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+
+	s := buf[:n]
+	if len(s) >= 4 && s[3] == ':' {
+		s = s[4:]
+	}
+	return assignString(rcv, string(s))
+}
+
+func decodeAggregate(r *bufio.Reader, rcv interface{}, resp3 bool) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(string(line))
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return nil
+	}
+
+	vals := make([]interface{}, n)
+	for i := range vals {
+		if err := decodeRESP(r, &vals[i], resp3); err != nil {
+			return err
+		}
+	}
+	return assignSlice(rcv, vals)
+}
+
+// decodeMap reads a RESP3 map (prefix '%'). The header count is the number
+// of field/value *pairs*, so the wire carries 2*n elements, not n -
+// unlike decodeAggregate's arrays/sets/pushes, which carry exactly n.
+func decodeMap(r *bufio.Reader, rcv interface{}, resp3 bool) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(string(line))
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return nil
+	}
+
+	pairs := make([]interface{}, 2*n)
+	for i := range pairs {
+		if err := decodeRESP(r, &pairs[i], resp3); err != nil {
+			return err
+		}
+	}
+	return assignMap(rcv, pairs)
+}
@@ -0,0 +1,103 @@
+package radix
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRESP(t *testing.T) {
+	cases := []struct {
+		name  string
+		resp3 bool
+		in    string
+		want  interface{}
+	}{
+		{"simple string", false, "+OK\r\n", "OK"},
+		{"error", false, "-ERR oops\r\n", nil},
+		{"int", false, ":1234\r\n", int64(1234)},
+		{"bulk string", false, "$5\r\nhello\r\n", "hello"},
+		{"null bulk string (resp2)", false, "$-1\r\n", nil},
+		{"array", false, "*2\r\n+foo\r\n+bar\r\n", []interface{}{"foo", "bar"}},
+		{"double", true, ",3.14\r\n", float64(3.14)},
+		{"boolean true", true, "#t\r\n", true},
+		{"boolean false", true, "#f\r\n", false},
+		{"big number", true, "(3492890328409238509324850943850943825024385\r\n", "3492890328409238509324850943850943825024385"},
+		{"null (resp3)", true, "_\r\n", nil},
+		{"verbatim string", true, "=9\r\ntxt:hello\r\n", "hello"},
+		{"set", true, "~2\r\n+foo\r\n+bar\r\n", []interface{}{"foo", "bar"}},
+		{"push", true, ">2\r\n+foo\r\n+bar\r\n", []interface{}{"foo", "bar"}},
+		// Regression: a RESP3 map's header count is the number of
+		// field/value *pairs*, so a 2-pair map carries 4 elements on the
+		// wire, not 2. Under-reading this desyncs every reply after it.
+		{"map", true, "%2\r\n+k1\r\n+v1\r\n+k2\r\n+v2\r\n", map[string]interface{}{"k1": "v1", "k2": "v2"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(c.in))
+			var got interface{}
+			err := decodeRESP(r, &got, c.resp3)
+			if c.name == "error" {
+				if err == nil || err.Error() != "ERR oops" {
+					t.Fatalf("decodeRESP() err = %v, want \"ERR oops\"", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeRESP() unexpected err: %v", err)
+			}
+			if m, ok := c.want.(map[string]interface{}); ok {
+				gm, ok := got.(map[string]interface{})
+				if !ok || len(gm) != len(m) {
+					t.Fatalf("decodeRESP() = %#v, want %#v", got, c.want)
+				}
+				for k, v := range m {
+					if gm[k] != v {
+						t.Fatalf("decodeRESP() = %#v, want %#v", got, c.want)
+					}
+				}
+				return
+			}
+			if s, ok := c.want.([]interface{}); ok {
+				gs, ok := got.([]interface{})
+				if !ok || len(gs) != len(s) {
+					t.Fatalf("decodeRESP() = %#v, want %#v", got, c.want)
+				}
+				for i := range s {
+					if gs[i] != s[i] {
+						t.Fatalf("decodeRESP() = %#v, want %#v", got, c.want)
+					}
+				}
+				return
+			}
+			if got != c.want {
+				t.Fatalf("decodeRESP() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestDecodeRESPMapFollowedByReply is a regression test for under-reading a
+// RESP3 map: if decodeMap only consumed n elements instead of 2*n, the
+// trailing +OK below would be read as part of the map instead of as the
+// next reply, desyncing the stream.
+func TestDecodeRESPMapFollowedByReply(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("%1\r\n+k\r\n+v\r\n+OK\r\n"))
+
+	var m map[string]interface{}
+	if err := decodeRESP(r, &m, true); err != nil {
+		t.Fatalf("decoding map: %v", err)
+	}
+	if len(m) != 1 || m["k"] != "v" {
+		t.Fatalf("map = %#v, want {k: v}", m)
+	}
+
+	var s string
+	if err := decodeRESP(r, &s, true); err != nil {
+		t.Fatalf("decoding trailing reply: %v", err)
+	}
+	if s != "OK" {
+		t.Fatalf("trailing reply = %q, want %q", s, "OK")
+	}
+}
@@ -0,0 +1,70 @@
+package radix
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+)
+
+// encodeRESP writes m to w as a resp2 request (redis only ever speaks resp2
+// for requests; RESP3 only changes the shape of replies). Only the types
+// Conn.Encode is documented to accept are supported.
+func encodeRESP(w *bufio.ReadWriter, m interface{}) error {
+	switch m := m.(type) {
+	case Cmd:
+		return encodeArray(w, cmdParts(m))
+	default:
+		return fmt.Errorf("radix: can't encode %T", m)
+	}
+}
+
+// cmdParts flattens a Cmd into the ordered list of arguments which make up
+// the resp request: the command name, then its keys, then its args.
+func cmdParts(c Cmd) []interface{} {
+	parts := make([]interface{}, 0, 1+len(c.Keys)+len(c.Args))
+	parts = append(parts, string(c.Cmd))
+	for _, k := range c.Keys {
+		parts = append(parts, string(k))
+	}
+	parts = append(parts, c.Args...)
+	return parts
+}
+
+func encodeArray(w *bufio.ReadWriter, parts []interface{}) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(parts)); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := encodeBulkString(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeBulkString(w *bufio.ReadWriter, v interface{}) (err error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int:
+		s = strconv.Itoa(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("radix: can't encode %T as a bulk string", v)
+	}
+
+	if _, err := fmt.Fprintf(w, "$%d\r\n", len(s)); err != nil {
+		return err
+	}
+	if _, err := w.WriteString(s); err != nil {
+		return err
+	}
+	_, err = w.WriteString("\r\n")
+	return err
+}
@@ -0,0 +1,87 @@
+package radix
+
+// Pipeline is an Action which batches together an ordered set of Cmds,
+// writing them to the Conn in a single round-trip and reading back their
+// replies in one pass, rather than doing a full write/read per Cmd. This is
+// a significant throughput win when issuing many commands against the same
+// key (or keyless commands) in a row.
+//
+// A Pipeline should either hold Cmds which all act on the same key, or
+// Cmds which act on no key at all; Cmds for different keys can't be
+// guaranteed to land on the same redis instance and so aren't supported.
+type Pipeline struct {
+	cmds []Cmd
+
+	// Errs holds the per-Cmd errors from the last call to Run, in the same
+	// order the Cmds were added in. It's only meaningful after Run returns;
+	// Run itself returns the first non-nil error found here, if any.
+	Errs []error
+}
+
+// Append adds cmd to the end of the Pipeline and returns the Pipeline for
+// chaining.
+func (p *Pipeline) Append(cmd Cmd) *Pipeline {
+	p.cmds = append(p.cmds, cmd)
+	return p
+}
+
+// Key implements the method for the Action interface. It returns the key
+// shared by the Pipeline's Cmds, or nil if none of them have one.
+func (p *Pipeline) Key() []byte {
+	for _, cmd := range p.cmds {
+		if k := cmd.Key(); k != nil {
+			return k
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of every Cmd in the Pipeline which has one. It's
+// used by things like cluster.Cluster to check that a Pipeline doesn't span
+// more than one hash slot before routing it to a single instance.
+func (p *Pipeline) Keys() [][]byte {
+	keys := make([][]byte, 0, len(p.cmds))
+	for _, cmd := range p.cmds {
+		if k := cmd.Key(); k != nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Run implements the method for the Action interface. It writes every Cmd
+// in the Pipeline to conn in a single pass, then reads back every reply,
+// unmarshalling each into its Cmd's Rcv. The first error encountered (be it
+// a write, read, or unmarshal error) is returned. A read or unmarshal error
+// doesn't stop the remaining replies from being read, so Errs is fully
+// populated; a write error stops Run at once and is recorded in Errs for the
+// Cmd being written and every Cmd after it. As with Cmd.Run, conn is closed
+// on any error since the pipeline's framing can no longer be trusted past
+// that point.
+func (p *Pipeline) Run(conn Conn) error {
+	p.Errs = make([]error, len(p.cmds))
+
+	for i, cmd := range p.cmds {
+		if err := conn.Encode(cmd); err != nil {
+			for j := i; j < len(p.cmds); j++ {
+				p.Errs[j] = err
+			}
+			conn.Close()
+			return err
+		}
+	}
+
+	var firstErr error
+	for i, cmd := range p.cmds {
+		if err := conn.Decode(cmd.Rcv); err != nil {
+			p.Errs[i] = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		conn.Close()
+	}
+	return firstErr
+}
@@ -0,0 +1,139 @@
+package radix
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pool is a dynamically sized pool of Conns to a single redis instance. It
+// implements the Client interface.
+type Pool struct {
+	network, addr string
+	dialOpts      []DialOpt
+	size          int
+
+	mu    sync.Mutex
+	conns []Conn
+}
+
+// NewPool creates and returns a Pool of the given size to the redis
+// instance at network/addr, dialing size Conns up front. Whenever no idle
+// Conn is available a new one is dialed on demand; the number of Conns
+// checked out at once is not limited, and at most size idle Conns are kept.
+func NewPool(network, addr string, size int, opts ...DialOpt) (*Pool, error) {
+	p := &Pool{
+		network:  network,
+		addr:     addr,
+		dialOpts: opts,
+		size:     size,
+	}
+	for i := 0; i < size; i++ {
+		c, err := Dial(network, addr, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns = append(p.conns, c)
+	}
+	return p, nil
+}
+
+func (p *Pool) get() (Conn, error) {
+	p.mu.Lock()
+	if n := len(p.conns); n > 0 {
+		c := p.conns[n-1]
+		p.conns = p.conns[:n-1]
+		p.mu.Unlock()
+		return c, nil
+	}
+	p.mu.Unlock()
+	return Dial(p.network, p.addr, p.dialOpts...)
+}
+
+// put returns c to the pool, unless the pool is already full, in which case
+// c is closed instead.
+func (p *Pool) put(c Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns) >= p.size {
+		c.Close()
+		return
+	}
+	p.conns = append(p.conns, c)
+}
+
+// Do implements the method for the Client interface.
+func (p *Pool) Do(a Action) error {
+	c, err := p.get()
+	if err != nil {
+		return err
+	}
+	if err := a.Run(c); err != nil {
+		c.Close()
+		return err
+	}
+	p.put(c)
+	return nil
+}
+
+// DoContext implements the method for the Client interface. If ctx carries
+// a deadline it's set on the underlying net.Conn so the in-flight
+// encode/decode unblocks; if ctx is canceled before the Action completes
+// the Conn is closed and discarded rather than returned to the pool, since
+// a canceled write/read can leave the connection's framing in an unknown
+// state.
+func (p *Pool) DoContext(ctx context.Context, a Action) error {
+	c, err := p.get()
+	if err != nil {
+		return err
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		c.NetConn().SetDeadline(dl)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- a.Run(c) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			c.Close()
+			if ctx.Err() != nil {
+				// the conn deadline fired before ctx.Done was observed
+				return ctx.Err()
+			}
+			return err
+		}
+		c.NetConn().SetDeadline(time.Time{})
+		p.put(c)
+		return nil
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+}
+
+// Close closes all Conns currently idle in the pool. It does not affect
+// Conns which are currently checked out via Do/DoContext.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+	return nil
+}
+
+// PoolFunc is a function which returns a Client for the given
+// network/address. It's used by things like Cluster to create Clients for
+// the instances it discovers.
+type PoolFunc func(network, addr string) (Client, error)
+
+// DefaultPoolFunc is used whenever a nil PoolFunc is given to something
+// which requires one. It creates a Pool of 10 Conns.
+var DefaultPoolFunc PoolFunc = func(network, addr string) (Client, error) {
+	return NewPool(network, addr, 10)
+}
@@ -0,0 +1,329 @@
+package radix
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PubSubMessage is a single message delivered on a channel a PubSubConn is
+// subscribed to.
+type PubSubMessage struct {
+	Channel string // the channel the message was published to
+	Pattern string // set if the subscription matching this message was a pattern one
+	Message []byte
+}
+
+// PubSubConn is a connection dedicated to receiving pubsub messages. Subscribe,
+// PSubscribe, Unsubscribe, and PUnsubscribe block until redis confirms the
+// (un)subscription.
+type PubSubConn interface {
+	Subscribe(channels ...string) error
+	PSubscribe(patterns ...string) error
+	Unsubscribe(channels ...string) error
+	PUnsubscribe(patterns ...string) error
+	Ping() error
+	Close() error
+}
+
+// persistentPubSub implements PubSubConn on top of a Conn which is
+// transparently redialed (via connFn) and re-subscribed to everything it
+// was previously subscribed to whenever it drops, so callers never have to
+// think about reconnect logic themselves.
+type persistentPubSub struct {
+	connFn func() (Conn, error)
+	msgCh  chan<- PubSubMessage
+
+	// mu serializes the (un)subscribe/ping calls and guards the fields
+	// below; it's held for the full round-trip of a call, including
+	// waiting for redis's confirmation.
+	mu        sync.Mutex
+	conn      Conn
+	channels  map[string]bool
+	patterns  map[string]bool
+	confirmCh chan []interface{}
+	// doneCh is closed by the reader goroutine for the current conn once it
+	// exits (i.e. once the connection has dropped), so watch can notice and
+	// reconnect even if nothing calls Subscribe/Unsubscribe/Ping meanwhile.
+	doneCh chan struct{}
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// PersistentPubSub returns a PubSubConn which dials connections via connFn
+// (or, if connFn is nil, via Dial(network, addr)), automatically
+// reconnecting and re-subscribing to all prior channels/patterns whenever
+// the underlying connection is lost. Messages are delivered on msgCh, which
+// the caller is expected to keep draining for as long as the PubSubConn is
+// in use.
+func PersistentPubSub(network, addr string, connFn func() (Conn, error), msgCh chan<- PubSubMessage) PubSubConn {
+	if connFn == nil {
+		connFn = func() (Conn, error) { return Dial(network, addr) }
+	}
+	p := &persistentPubSub{
+		connFn:   connFn,
+		msgCh:    msgCh,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		closeCh:  make(chan struct{}),
+	}
+	p.mu.Lock()
+	p.reconnect()
+	p.mu.Unlock()
+	go p.watch()
+	return p
+}
+
+// reconnect dials a new conn via connFn and re-subscribes it to every
+// channel/pattern already tracked in p.channels/p.patterns. It must be
+// called with p.mu held. Dial/resubscribe failures are swallowed here;
+// they'll surface to the caller as errors from the next (un)subscribe/ping
+// call, and watch will also keep retrying in the background regardless of
+// whether anything calls in.
+func (p *persistentPubSub) reconnect() {
+	conn, err := p.connFn()
+	if err != nil {
+		return
+	}
+
+	confirmCh := make(chan []interface{}, 1)
+	doneCh := make(chan struct{})
+	go func() {
+		readPubSubFrames(conn, confirmCh, p.msgCh)
+		close(doneCh)
+	}()
+
+	p.conn = conn
+	p.confirmCh = confirmCh
+	p.doneCh = doneCh
+
+	if len(p.channels) > 0 {
+		if err := p.sendAndConfirm("SUBSCRIBE", keys(p.channels), len(p.channels)); err != nil {
+			conn.Close()
+			p.conn = nil
+			return
+		}
+	}
+	if len(p.patterns) > 0 {
+		if err := p.sendAndConfirm("PSUBSCRIBE", keys(p.patterns), len(p.patterns)); err != nil {
+			conn.Close()
+			p.conn = nil
+		}
+	}
+}
+
+// watch is the background counterpart to do's reconnect-on-error: it
+// notices when the current conn's reader goroutine exits - which happens
+// whenever the connection drops, not just when a caller happens to be
+// making a (un)subscribe/ping call at the time - and reconnects, so a
+// caller which only drains msgCh still recovers automatically.
+func (p *persistentPubSub) watch() {
+	for {
+		p.mu.Lock()
+		doneCh := p.doneCh
+		haveConn := p.conn != nil
+		p.mu.Unlock()
+
+		if !haveConn {
+			select {
+			case <-p.closeCh:
+				return
+			case <-time.After(time.Second):
+			}
+			p.mu.Lock()
+			if p.conn == nil {
+				p.reconnect()
+			}
+			p.mu.Unlock()
+			continue
+		}
+
+		select {
+		case <-p.closeCh:
+			return
+		case <-doneCh:
+		}
+
+		p.mu.Lock()
+		select {
+		case <-p.closeCh:
+			p.mu.Unlock()
+			return
+		default:
+		}
+		// only reconnect if nothing else (e.g. a concurrent do() call)
+		// already replaced this generation's conn
+		if p.doneCh == doneCh {
+			if p.conn != nil {
+				p.conn.Close()
+				p.conn = nil
+			}
+			p.reconnect()
+		}
+		p.mu.Unlock()
+	}
+}
+
+func keys(m map[string]bool) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+// readPubSubFrames runs for the lifetime of conn, reading every frame off
+// of it: (un)subscribe confirmations are handed to confirmCh for whichever
+// call is waiting on them, and actual messages are forwarded to msgCh.
+func readPubSubFrames(conn Conn, confirmCh chan<- []interface{}, msgCh chan<- PubSubMessage) {
+	defer close(confirmCh)
+	for {
+		var frame []interface{}
+		if err := conn.Decode(&frame); err != nil {
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+
+		kind, _ := frame[0].(string)
+		switch kind {
+		case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "pong":
+			confirmCh <- frame
+		case "message":
+			channel, _ := frame[1].(string)
+			msg, _ := frame[2].(string)
+			msgCh <- PubSubMessage{Channel: channel, Message: []byte(msg)}
+		case "pmessage":
+			pattern, _ := frame[1].(string)
+			channel, _ := frame[2].(string)
+			msg, _ := frame[3].(string)
+			msgCh <- PubSubMessage{Channel: channel, Pattern: pattern, Message: []byte(msg)}
+		}
+	}
+}
+
+// sendAndConfirm writes a (P)SUBSCRIBE/(P)UNSUBSCRIBE command for the given
+// args and blocks until `want` confirmation frames for it have come back
+// from readPubSubFrames. It must be called with p.mu held.
+func (p *persistentPubSub) sendAndConfirm(cmdName string, args []string, want int) error {
+	cmd := Cmd{}.C(cmdName)
+	for _, a := range args {
+		cmd = cmd.A(a)
+	}
+	if err := p.conn.Encode(cmd); err != nil {
+		return err
+	}
+	for i := 0; i < want; i++ {
+		if _, ok := <-p.confirmCh; !ok {
+			return fmt.Errorf("radix: connection closed waiting for %s confirmation", cmdName)
+		}
+	}
+	return nil
+}
+
+// do runs fn against the current connection, reconnecting (once) and
+// retrying if fn fails because the connection has dropped.
+func (p *persistentPubSub) do(fn func() error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		p.reconnect()
+	}
+	if p.conn == nil {
+		return fmt.Errorf("radix: no pubsub connection available")
+	}
+
+	if err := fn(); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.reconnect()
+		if p.conn == nil {
+			return err
+		}
+		return fn()
+	}
+	return nil
+}
+
+func (p *persistentPubSub) Subscribe(channels ...string) error {
+	return p.do(func() error {
+		if err := p.sendAndConfirm("SUBSCRIBE", channels, len(channels)); err != nil {
+			return err
+		}
+		for _, ch := range channels {
+			p.channels[ch] = true
+		}
+		return nil
+	})
+}
+
+func (p *persistentPubSub) PSubscribe(patterns ...string) error {
+	return p.do(func() error {
+		if err := p.sendAndConfirm("PSUBSCRIBE", patterns, len(patterns)); err != nil {
+			return err
+		}
+		for _, pt := range patterns {
+			p.patterns[pt] = true
+		}
+		return nil
+	})
+}
+
+func (p *persistentPubSub) Unsubscribe(channels ...string) error {
+	return p.do(func() error {
+		if err := p.sendAndConfirm("UNSUBSCRIBE", channels, len(channels)); err != nil {
+			return err
+		}
+		for _, ch := range channels {
+			delete(p.channels, ch)
+		}
+		return nil
+	})
+}
+
+func (p *persistentPubSub) PUnsubscribe(patterns ...string) error {
+	return p.do(func() error {
+		if err := p.sendAndConfirm("PUNSUBSCRIBE", patterns, len(patterns)); err != nil {
+			return err
+		}
+		for _, pt := range patterns {
+			delete(p.patterns, pt)
+		}
+		return nil
+	})
+}
+
+// Ping checks that the underlying connection is alive by writing a PING to
+// it and waiting up to 5 seconds for the reply. During pubsub mode that reply
+// is a pong frame, which readPubSubFrames hands to confirmCh just like an
+// (un)subscribe confirmation.
+func (p *persistentPubSub) Ping() error {
+	return p.do(func() error {
+		if err := p.conn.Encode(Cmd{}.C("PING")); err != nil {
+			return err
+		}
+		select {
+		case _, ok := <-p.confirmCh:
+			if !ok {
+				return fmt.Errorf("radix: connection closed waiting for PING reply")
+			}
+			return nil
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("radix: timed out waiting for PING reply")
+		}
+	})
+}
+
+func (p *persistentPubSub) Close() error {
+	p.closeOnce.Do(func() { close(p.closeCh) })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.Close()
+}
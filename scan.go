@@ -0,0 +1,124 @@
+package radix
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ScanOpts describes how a Scanner should iterate the keyspace (for SCAN)
+// or a single key's contents (for HSCAN/SSCAN/ZSCAN).
+type ScanOpts struct {
+	// Command is the scan command to use: "SCAN", "HSCAN", "SSCAN", or
+	// "ZSCAN". Defaults to "SCAN" if left empty.
+	Command string
+
+	// Key is the key whose contents are being scanned. Required for
+	// HSCAN/SSCAN/ZSCAN, ignored for SCAN.
+	Key string
+
+	// Pattern, if set, is passed along as the scan command's MATCH arg.
+	Pattern string
+
+	// Count, if positive, is passed along as the scan command's COUNT arg,
+	// as a hint to redis for how many elements to examine per call.
+	Count int
+}
+
+// Scanner iterates the results of a cursor-driven SCAN/HSCAN/SSCAN/ZSCAN,
+// issuing further scan calls against its Client as needed until the cursor
+// returns to "0". It works over any Client, including a Pool.
+type Scanner struct {
+	client Client
+	opts   ScanOpts
+
+	cursor string
+	buf    []string
+	err    error
+	done   bool
+}
+
+// NewScanner returns a Scanner which iterates via opts against c.
+func NewScanner(c Client, opts ScanOpts) *Scanner {
+	if opts.Command == "" {
+		opts.Command = "SCAN"
+	}
+	return &Scanner{client: c, opts: opts, cursor: "0"}
+}
+
+// Next sets *v to the next key (or member/field/element, for
+// HSCAN/SSCAN/ZSCAN) found by the iteration and returns true, or returns
+// false once iteration is exhausted or an error occurs. Every element of
+// the scan reply is yielded in order, so for HSCAN each field is followed
+// by its value and for ZSCAN each member by its score. Close should be
+// checked for an error once Next returns false.
+func (s *Scanner) Next(v *string) bool {
+	for len(s.buf) == 0 {
+		if s.done || s.err != nil {
+			return false
+		}
+		if !s.fill() {
+			return false
+		}
+	}
+
+	*v = s.buf[0]
+	s.buf = s.buf[1:]
+	return true
+}
+
+// fill issues one more scan call, appending whatever it finds to s.buf and
+// advancing s.cursor. It returns false (stashing the error in s.err) if the
+// call failed or the reply couldn't be understood.
+func (s *Scanner) fill() bool {
+	cmd := Cmd{}.C(s.opts.Command)
+	if s.opts.Key != "" {
+		cmd = cmd.K(s.opts.Key)
+	}
+	cmd = cmd.A(s.cursor)
+	if s.opts.Pattern != "" {
+		cmd = cmd.A("MATCH").A(s.opts.Pattern)
+	}
+	if s.opts.Count > 0 {
+		cmd = cmd.A("COUNT").A(strconv.Itoa(s.opts.Count))
+	}
+
+	var reply []interface{}
+	if err := s.client.Do(cmd.R(&reply)); err != nil {
+		s.err = err
+		return false
+	}
+	if len(reply) != 2 {
+		s.err = fmt.Errorf("radix: unexpected scan reply of length %d", len(reply))
+		return false
+	}
+
+	cursor, ok := reply[0].(string)
+	if !ok {
+		s.err = fmt.Errorf("radix: unexpected scan cursor type %T", reply[0])
+		return false
+	}
+
+	items, ok := reply[1].([]interface{})
+	if !ok {
+		s.err = fmt.Errorf("radix: unexpected scan items type %T", reply[1])
+		return false
+	}
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			s.err = fmt.Errorf("radix: unexpected scan item type %T", item)
+			return false
+		}
+		s.buf = append(s.buf, str)
+	}
+
+	s.cursor = cursor
+	s.done = cursor == "0"
+	return true
+}
+
+// Close returns any error encountered during iteration. It's safe (and
+// expected) to call this once Next has returned false.
+func (s *Scanner) Close() error {
+	return s.err
+}
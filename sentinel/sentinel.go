@@ -0,0 +1,271 @@
+// Package sentinel implements a Client which uses redis sentinel to
+// discover and automatically fail over to the current master of a given
+// master set, removing the need to hand-roll HA on top of a single master
+// Pool.
+//
+// TODO better docs
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	radix "github.com/v2e4lisp/radix.v3"
+)
+
+type errClient struct {
+	err error
+}
+
+func (ec errClient) Do(radix.Action) error {
+	return ec.err
+}
+
+func (ec errClient) DoContext(context.Context, radix.Action) error {
+	return ec.err
+}
+
+func (ec errClient) Close() error {
+	return nil
+}
+
+// Sentinel is a Client which maintains a Pool to the current master of a
+// sentinel-monitored master set, following +switch-master failover events
+// as sentinel announces them. All methods on Sentinel are thread-safe.
+type Sentinel struct {
+	pf         radix.PoolFunc
+	masterName string
+
+	sync.RWMutex
+	sentinelAddrs []string
+	masterPool    radix.Client
+	masterAddr    string
+
+	closeCh chan struct{}
+}
+
+// NewSentinel initializes and returns a Sentinel instance for the given
+// master name. It will ask every sentinel address given, in turn, for the
+// current master until one answers, establish a Pool to that master via
+// pf, and start watching for +switch-master events.
+//
+// If pf is nil then radix.DefaultPoolFunc will be used.
+func NewSentinel(pf radix.PoolFunc, masterName string, sentinelAddrs ...string) (*Sentinel, error) {
+	if pf == nil {
+		pf = radix.DefaultPoolFunc
+	}
+	s := &Sentinel{
+		pf:            pf,
+		masterName:    masterName,
+		sentinelAddrs: sentinelAddrs,
+		closeCh:       make(chan struct{}),
+	}
+
+	if err := s.Sync(); err != nil {
+		return nil, err
+	}
+
+	go s.watch()
+	go s.syncEvery(30 * time.Second) // TODO make period configurable?
+
+	return s, nil
+}
+
+// anySentinel dials every known sentinel address in turn, returning the
+// first connection it manages to establish.
+func (s *Sentinel) anySentinel() (radix.Conn, error) {
+	s.RLock()
+	addrs := append([]string(nil), s.sentinelAddrs...)
+	s.RUnlock()
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := radix.Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no available known sentinel instances")
+	}
+	return nil, lastErr
+}
+
+// masterAddrFromSentinel asks a sentinel for the current address of
+// s.masterName.
+func (s *Sentinel) masterAddrFromSentinel() (string, error) {
+	conn, err := s.anySentinel()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var parts []string
+	cmd := radix.Cmd{}.C("SENTINEL").A("get-master-addr-by-name").A(s.masterName).R(&parts)
+	if err := cmd.Run(conn); err != nil {
+		return "", err
+	}
+	if len(parts) != 2 {
+		return "", fmt.Errorf("sentinel: unexpected get-master-addr-by-name reply: %v", parts)
+	}
+	return parts[0] + ":" + parts[1], nil
+}
+
+// Sync re-asks sentinel for the current master address and, if it's
+// different than the one currently in use, replaces the master Pool. This
+// is called periodically as a fallback to the +switch-master subscription
+// (which updates the master Pool itself on failover), but can also be
+// called manually at any time.
+func (s *Sentinel) Sync() error {
+	addr, err := s.masterAddrFromSentinel()
+	if err != nil {
+		return err
+	}
+	return s.setMaster(addr)
+}
+
+func (s *Sentinel) setMaster(addr string) error {
+	s.RLock()
+	same := addr == s.masterAddr
+	s.RUnlock()
+	if same {
+		return nil
+	}
+
+	p, err := s.pf("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %s", addr, err)
+	}
+
+	s.Lock()
+	old := s.masterPool
+	s.masterPool = p
+	s.masterAddr = addr
+	s.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (s *Sentinel) syncEvery(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			// errors are dropped here; watch's +switch-master subscription
+			// is the primary way master changes are picked up, this is
+			// just a fallback in case that subscription drops silently.
+			s.Sync()
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// watch holds a subscription to +switch-master on a sentinel connection for
+// as long as it can, updating the master Pool the moment a failover is
+// announced instead of waiting on the next periodic Sync. If the
+// subscription dies it's re-established against any available sentinel.
+func (s *Sentinel) watch() {
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		default:
+		}
+
+		conn, err := s.anySentinel()
+		if err != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		sub := radix.Cmd{}.C("SUBSCRIBE").A("+switch-master")
+		if err := sub.Run(conn); err != nil {
+			conn.Close()
+			time.Sleep(time.Second)
+			continue
+		}
+
+		s.watchConn(conn)
+	}
+}
+
+func (s *Sentinel) watchConn(conn radix.Conn) {
+	defer conn.Close()
+
+	// closing conn on Close unblocks the Decode below, so watch can exit
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.closeCh:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var msg []string
+		if err := conn.Decode(&msg); err != nil {
+			return
+		}
+		if len(msg) != 3 || msg[0] != "message" {
+			continue
+		}
+
+		// payload is "<master name> <old ip> <old port> <new ip> <new port>"
+		fields := strings.Fields(msg[2])
+		if len(fields) != 5 || fields[0] != s.masterName {
+			continue
+		}
+		s.setMaster(fields[3] + ":" + fields[4])
+	}
+}
+
+// pool returns the current master Pool, or an errClient if none has been
+// established yet.
+func (s *Sentinel) pool() radix.Client {
+	s.RLock()
+	defer s.RUnlock()
+	if s.masterPool == nil {
+		return errClient{err: errors.New("sentinel: no master pool established")}
+	}
+	return s.masterPool
+}
+
+// Do performs an Action against the current master. It implements the
+// method for the Client interface.
+func (s *Sentinel) Do(a radix.Action) error {
+	return s.pool().Do(a)
+}
+
+// DoContext is like Do, but aborts and returns ctx.Err() if ctx is canceled
+// or its deadline is exceeded before the Action completes. It implements
+// the method for the Client interface.
+func (s *Sentinel) DoContext(ctx context.Context, a radix.Action) error {
+	return s.pool().DoContext(ctx, a)
+}
+
+// Close cleans up all goroutines spawned by Sentinel and closes its master
+// Pool.
+func (s *Sentinel) Close() error {
+	close(s.closeCh)
+
+	s.Lock()
+	defer s.Unlock()
+	if s.masterPool != nil {
+		return s.masterPool.Close()
+	}
+	return nil
+}